@@ -0,0 +1,50 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package changer talks to the physical (or simulated) media changer: the
+// robot that moves tape cartridges between storage slots, transfer slots
+// (drives) and import/export slots.
+package changer // import "tapr.space/store/tape/changer"
+
+import (
+	"context"
+
+	"tapr.space/store/tape"
+)
+
+// A Changer drives a media changer. Every method takes a context.Context, so
+// a caller can bound how long a stuck robot is allowed to hold the calling
+// goroutine instead of hanging forever.
+type Changer interface {
+	// Load moves the volume at src into the transfer slot dst.
+	Load(ctx context.Context, src, dst tape.Location) error
+
+	// Unload moves the volume at src, a transfer slot, to dst.
+	Unload(ctx context.Context, src, dst tape.Location) error
+
+	// Transfer moves the volume at src directly to dst without mounting it.
+	Transfer(ctx context.Context, src, dst tape.Location) error
+
+	// Status reports the changer's current view of every slot, keyed by
+	// category.
+	Status(ctx context.Context) (map[tape.SlotCategory][]Slot, error)
+}
+
+// A Slot is the changer's view of a single physical slot: its address,
+// category, and the volume currently sitting in it, if any.
+type Slot struct {
+	Addr     int
+	Category tape.SlotCategory
+	Volume   *tape.Volume
+}
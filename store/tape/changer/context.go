@@ -0,0 +1,67 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package changer
+
+import (
+	"context"
+	"time"
+
+	"tapr.space/store/tape"
+)
+
+// WithDeadline returns ctx bounded by d, and a cancel func that must be
+// called. A zero or negative d leaves ctx untouched, so callers can treat a
+// "no timeout configured" option as a no-op rather than special-casing it.
+func WithDeadline(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, d)
+}
+
+// CallLoad invokes chgr.Load, bounded by timeout. It is the single place
+// inv's postgres and bolt backends route their load-timeout option through,
+// so the two don't each maintain their own copy of the same wrapper.
+func CallLoad(ctx context.Context, chgr Changer, timeout time.Duration, src, dst tape.Location) error {
+	ctx, cancel := WithDeadline(ctx, timeout)
+	defer cancel()
+
+	return chgr.Load(ctx, src, dst)
+}
+
+// CallUnload invokes chgr.Unload, bounded by timeout.
+func CallUnload(ctx context.Context, chgr Changer, timeout time.Duration, src, dst tape.Location) error {
+	ctx, cancel := WithDeadline(ctx, timeout)
+	defer cancel()
+
+	return chgr.Unload(ctx, src, dst)
+}
+
+// CallTransfer invokes chgr.Transfer, bounded by timeout.
+func CallTransfer(ctx context.Context, chgr Changer, timeout time.Duration, src, dst tape.Location) error {
+	ctx, cancel := WithDeadline(ctx, timeout)
+	defer cancel()
+
+	return chgr.Transfer(ctx, src, dst)
+}
+
+// CallStatus invokes chgr.Status, bounded by timeout.
+func CallStatus(ctx context.Context, chgr Changer, timeout time.Duration) (map[tape.SlotCategory][]Slot, error) {
+	ctx, cancel := WithDeadline(ctx, timeout)
+	defer cancel()
+
+	return chgr.Status(ctx)
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package changer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tapr.space/store/tape"
+)
+
+func TestWithDeadlineNoTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	bounded, cancel := WithDeadline(ctx, 0)
+	defer cancel()
+
+	if bounded != ctx {
+		t.Error("WithDeadline(ctx, 0) returned a different context, want ctx untouched")
+	}
+
+	if _, ok := bounded.Deadline(); ok {
+		t.Error("WithDeadline(ctx, 0) produced a context with a deadline")
+	}
+
+	bounded, cancel = WithDeadline(ctx, -time.Second)
+	defer cancel()
+
+	if bounded != ctx {
+		t.Error("WithDeadline(ctx, negative) returned a different context, want ctx untouched")
+	}
+}
+
+func TestWithDeadlineTimeout(t *testing.T) {
+	bounded, cancel := WithDeadline(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	deadline, ok := bounded.Deadline()
+	if !ok {
+		t.Fatal("WithDeadline(ctx, 10ms) produced a context with no deadline")
+	}
+
+	if time.Until(deadline) > 10*time.Millisecond {
+		t.Errorf("deadline %v too far in the future", deadline)
+	}
+
+	select {
+	case <-bounded.Done():
+	case <-time.After(time.Second):
+		t.Error("context not done after its deadline passed")
+	}
+
+	if bounded.Err() != context.DeadlineExceeded {
+		t.Errorf("Err() = %v, want %v", bounded.Err(), context.DeadlineExceeded)
+	}
+}
+
+// slowChanger blocks on every call until ctx is done, simulating a stuck
+// changer; it reports whether its caller's context carried a deadline.
+type slowChanger struct {
+	hadDeadline chan bool
+}
+
+func (c *slowChanger) await(ctx context.Context) error {
+	_, ok := ctx.Deadline()
+	c.hadDeadline <- ok
+
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func (c *slowChanger) Load(ctx context.Context, _, _ tape.Location) error     { return c.await(ctx) }
+func (c *slowChanger) Unload(ctx context.Context, _, _ tape.Location) error   { return c.await(ctx) }
+func (c *slowChanger) Transfer(ctx context.Context, _, _ tape.Location) error { return c.await(ctx) }
+
+func (c *slowChanger) Status(ctx context.Context) (map[tape.SlotCategory][]Slot, error) {
+	return nil, c.await(ctx)
+}
+
+var _ Changer = (*slowChanger)(nil)
+
+func TestCallFunctionsBoundAStuckChanger(t *testing.T) {
+	loc := tape.Location{Addr: 1, Category: tape.StorageSlot}
+
+	cases := []struct {
+		name string
+		call func(ctx context.Context, chgr Changer, timeout time.Duration) error
+	}{
+		{"CallLoad", func(ctx context.Context, chgr Changer, timeout time.Duration) error {
+			return CallLoad(ctx, chgr, timeout, loc, loc)
+		}},
+		{"CallUnload", func(ctx context.Context, chgr Changer, timeout time.Duration) error {
+			return CallUnload(ctx, chgr, timeout, loc, loc)
+		}},
+		{"CallTransfer", func(ctx context.Context, chgr Changer, timeout time.Duration) error {
+			return CallTransfer(ctx, chgr, timeout, loc, loc)
+		}},
+		{"CallStatus", func(ctx context.Context, chgr Changer, timeout time.Duration) error {
+			_, err := CallStatus(ctx, chgr, timeout)
+			return err
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chgr := &slowChanger{hadDeadline: make(chan bool, 1)}
+
+			done := make(chan error, 1)
+			go func() { done <- c.call(context.Background(), chgr, 10*time.Millisecond) }()
+
+			select {
+			case hadDeadline := <-chgr.hadDeadline:
+				if !hadDeadline {
+					t.Error("changer was called with a context carrying no deadline")
+				}
+			case <-time.After(time.Second):
+				t.Fatal("changer was never called")
+			}
+
+			select {
+			case err := <-done:
+				if err != context.DeadlineExceeded {
+					t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("call did not return once its timeout elapsed")
+			}
+		})
+	}
+}
+
+func TestCallFunctionsRunUnboundedWithoutATimeout(t *testing.T) {
+	loc := tape.Location{Addr: 1, Category: tape.StorageSlot}
+
+	chgr := &slowChanger{hadDeadline: make(chan bool, 1)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- CallLoad(ctx, chgr, 0, loc, loc) }()
+
+	select {
+	case hadDeadline := <-chgr.hadDeadline:
+		if hadDeadline {
+			t.Error("changer was called with a deadline despite timeout being 0")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("changer was never called")
+	}
+
+	select {
+	case err := <-done:
+		t.Errorf("call returned early with err = %v, want it still blocked", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("call did not return after the context was cancelled")
+	}
+}
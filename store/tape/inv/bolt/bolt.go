@@ -0,0 +1,891 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bolt implements an embedded, bbolt-backed inv.Inventory, suitable
+// for single-node or edge deployments that don't want to run a standalone
+// postgres instance.
+package bolt // import "tapr.space/store/tape/inv/bolt"
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"strings"
+	"sync"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"tapr.space"
+	"tapr.space/bitmask"
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+	"tapr.space/store/tape/inv/journal"
+)
+
+func init() {
+	inv.Register("bolt", New)
+}
+
+// Buckets. bbolt's single-writer model means the SELECT ... FOR UPDATE
+// pattern the postgres backend needs is trivially true for any single
+// db.Update closure. Load, Unload and Transfer still split their work across
+// two closures around the changer call, exactly as postgres splits its two
+// Transacts, so the long-running physical move doesn't hold the one writer
+// lock and serialize every other volume's operations behind it.
+var (
+	bucketVolumes     = []byte("volumes") // serial -> gob(tape.Volume)
+	bucketTree        = []byte("tree")    // path -> serial
+	bucketHomes       = []byte("homes")   // serial -> gob(tape.Location), mirrors Volume.Home
+	bucketIndex       = []byte("index")   // (addr,category) -> serial, keeps Loaded O(1)
+	bucketJournalName = []byte("journal") // seq -> gob(journal.Entry), see journal.go
+)
+
+var allBuckets = [][]byte{bucketVolumes, bucketTree, bucketHomes, bucketIndex, bucketJournalName}
+
+type impl struct {
+	db      *bbolt.DB
+	journal journal.Journal
+
+	mu sync.Mutex
+
+	prefixCleaning string
+
+	loadTimeout   time.Duration
+	unloadTimeout time.Duration
+	statusTimeout time.Duration
+}
+
+var _ inv.Inventory = (*impl)(nil)
+var _ inv.Recoverable = (*impl)(nil)
+var _ inv.ContextualInventory = (*impl)(nil)
+
+// New returns a new bbolt-backed inventory implementation. The "path" option
+// names the database file; "cleaning-prefix" is used by Audit exactly as in
+// the postgres backend. "load-timeout", "unload-timeout" and
+// "status-timeout" bound the corresponding changer calls; unset or empty
+// means no deadline.
+func New(opts map[string]string) (inv.Inventory, error) {
+	const op = "inv/bolt.New"
+
+	requiredOpts := []string{"path", "cleaning-prefix"}
+
+	for _, opt := range requiredOpts {
+		if _, ok := opts[opt]; !ok {
+			return nil, errors.E(op, errors.Strf("the %s option must be specified", opt))
+		}
+	}
+
+	db, err := bbolt.Open(opts["path"], 0o600, nil)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	loadTimeout, err := parseTimeout(opts, "load-timeout")
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	unloadTimeout, err := parseTimeout(opts, "unload-timeout")
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	statusTimeout, err := parseTimeout(opts, "status-timeout")
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return &impl{
+		db:             db,
+		journal:        &bucketJournal{db: db},
+		prefixCleaning: opts["cleaning-prefix"],
+		loadTimeout:    loadTimeout,
+		unloadTimeout:  unloadTimeout,
+		statusTimeout:  statusTimeout,
+	}, nil
+}
+
+// parseTimeout parses the named opt as a time.Duration, e.g. "30s". An
+// unset or empty opt yields a zero duration, meaning "no deadline".
+func parseTimeout(opts map[string]string, name string) (time.Duration, error) {
+	s := opts[name]
+	if s == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func encodeVolume(v tape.Volume) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeVolume(data []byte) (tape.Volume, error) {
+	var v tape.Volume
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+
+	return v, err
+}
+
+func encodeLocation(loc tape.Location) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(loc); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// indexKey is the secondary-index key for a location: 4 bytes of Addr
+// (big-endian, so keys sort sensibly) followed by the category byte.
+func indexKey(loc tape.Location) []byte {
+	key := make([]byte, 5)
+	binary.BigEndian.PutUint32(key[:4], uint32(loc.Addr))
+	key[4] = byte(loc.Category)
+
+	return key
+}
+
+func getVolume(tx *bbolt.Tx, serial tape.Serial) (tape.Volume, error) {
+	data := tx.Bucket(bucketVolumes).Get([]byte(serial))
+	if data == nil {
+		return tape.Volume{}, errors.Strf("no such volume %q", serial)
+	}
+
+	return decodeVolume(data)
+}
+
+// putVolume writes v to the volumes bucket and keeps the homes bucket, a
+// by-serial mirror of Volume.Home, in sync.
+func putVolume(tx *bbolt.Tx, v tape.Volume) error {
+	data, err := encodeVolume(v)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(bucketVolumes).Put([]byte(v.Serial), data); err != nil {
+		return err
+	}
+
+	homeData, err := encodeLocation(v.Home)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(bucketHomes).Put([]byte(v.Serial), homeData)
+}
+
+func putIndex(tx *bbolt.Tx, loc tape.Location, serial tape.Serial) error {
+	return tx.Bucket(bucketIndex).Put(indexKey(loc), []byte(serial))
+}
+
+func deleteIndex(tx *bbolt.Tx, loc tape.Location) error {
+	return tx.Bucket(bucketIndex).Delete(indexKey(loc))
+}
+
+func (p *impl) Volumes() ([]tape.Volume, error) {
+	const op = "inv/bolt.Volumes"
+
+	var vs []tape.Volume
+
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketVolumes).ForEach(func(_, data []byte) error {
+			v, err := decodeVolume(data)
+			if err != nil {
+				return err
+			}
+
+			vs = append(vs, v)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return vs, nil
+}
+
+func (p *impl) Audit(chgr changer.Changer) error {
+	const op = "inv/bolt.Audit"
+
+	slots, err := changer.CallStatus(context.Background(), chgr, p.statusTimeout)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	err = p.db.Update(func(tx *bbolt.Tx) error {
+		for _, t := range tape.SlotCategories {
+			var flags uint32
+			if t == tape.TransferSlot {
+				bitmask.Set(&flags, tape.StatusMounted)
+			}
+
+			for _, slot := range slots[t] {
+				sv := slot.Volume
+				if sv == nil {
+					continue
+				}
+
+				category := tape.Scratch
+				if strings.HasPrefix(string(sv.Serial), p.prefixCleaning) {
+					category = tape.Cleaning
+				}
+
+				v := tape.Volume{
+					Serial:   sv.Serial,
+					Location: tape.Location{Addr: slot.Addr, Category: slot.Category},
+					Category: category,
+					Flags:    flags,
+				}
+
+				if existing, err := getVolume(tx, sv.Serial); err == nil {
+					v.Home = existing.Home
+				}
+
+				if err := putVolume(tx, v); err != nil {
+					return err
+				}
+
+				if err := putIndex(tx, v.Location, v.Serial); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+func (p *impl) Create(path tapr.PathName, serial string) error {
+	const op = "inv/bolt.Create"
+
+	err := p.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketTree).Put([]byte(path), []byte(serial))
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+func (p *impl) Lookup(path tapr.PathName) (tape.Volume, error) {
+	const op = "inv/bolt.Lookup"
+
+	var v tape.Volume
+
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		serial := tx.Bucket(bucketTree).Get([]byte(path))
+		if serial == nil {
+			return errors.Strf("no volume at path %q", path)
+		}
+
+		vv, err := getVolume(tx, tape.Serial(serial))
+		if err != nil {
+			return err
+		}
+
+		v = vv
+
+		return nil
+	})
+	if err != nil {
+		return tape.Volume{}, errors.E(op, err)
+	}
+
+	return v, nil
+}
+
+// snapshot returns a copy of the volume record for serial without holding the
+// writer lock, so its validation can happen before the journal entry that
+// must precede the changer call.
+func (p *impl) snapshot(serial tape.Serial) (tape.Volume, error) {
+	var v tape.Volume
+
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		vv, err := getVolume(tx, serial)
+		if err != nil {
+			return err
+		}
+
+		v = vv
+
+		return nil
+	})
+
+	return v, err
+}
+
+func (p *impl) Load(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	return p.LoadCtx(context.Background(), serial, dst, chgr)
+}
+
+// LoadCtx is Load, bounded by ctx. If ctx is cancelled or its deadline fires
+// while the changer is moving the tape, the load is left journaled for
+// Recover to reconcile rather than resolved here.
+func (p *impl) LoadCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/bolt.Load"
+
+	v, err := p.snapshot(serial)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if v.Location.Category != tape.StorageSlot && v.Location.Category != tape.ImportExportSlot {
+		return errors.E(op, errors.Strf("invalid source slot for load operation"))
+	}
+
+	if dst.Category != tape.TransferSlot {
+		return errors.E(op, errors.Strf("invalid destination slot for load operation"))
+	}
+
+	src := v.Location
+
+	// Begin commits before the changer is invoked, so a crash mid-move can be
+	// reconciled by Recover.
+	txnID, err := p.journal.Begin(ctx, journal.OpLoad, serial, src, dst)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	// Mark the volume in flight and commit before calling the changer. The
+	// changer call itself can take tens of seconds; bbolt has a single
+	// writer, so holding this transaction open across it would serialize
+	// every other volume's Load, Unload, Transfer, Alloc, Create, Update and
+	// Audit behind this one tape move.
+	err = p.db.Update(func(tx *bbolt.Tx) error {
+		v, err := getVolume(tx, serial)
+		if err != nil {
+			return err
+		}
+
+		// snapshot validated an unlocked read; re-check against the row now
+		// that it's locked, in case a concurrent move changed it in between,
+		// and use this location - not the unlocked snapshot's - from here on.
+		if v.Location.Category != tape.StorageSlot && v.Location.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid source slot for load operation")
+		}
+
+		src = v.Location
+
+		bitmask.Set(&v.Flags, tape.StatusTransfering)
+		bitmask.Set(&v.Flags, tape.StatusMounted)
+		v.Home = src
+
+		return putVolume(tx, v)
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := changer.CallLoad(ctx, chgr, p.loadTimeout, src, dst); err != nil {
+		return errors.E(op, err)
+	}
+
+	err = p.db.Update(func(tx *bbolt.Tx) error {
+		v, err := getVolume(tx, serial)
+		if err != nil {
+			return err
+		}
+
+		bitmask.Clear(&v.Flags, tape.StatusTransfering)
+
+		if v.Category == tape.Allocating {
+			v.Category = tape.Allocated
+		}
+
+		if err := deleteIndex(tx, src); err != nil {
+			return err
+		}
+
+		v.Location = dst
+
+		if err := putIndex(tx, dst, v.Serial); err != nil {
+			return err
+		}
+
+		return putVolume(tx, v)
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := p.journal.Complete(ctx, txnID); err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+func (p *impl) Unload(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	return p.UnloadCtx(context.Background(), serial, dst, chgr)
+}
+
+// UnloadCtx is Unload, bounded by ctx. See LoadCtx for the recovery
+// implication of a context that expires mid-move.
+func (p *impl) UnloadCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/bolt.Unload"
+
+	v, err := p.snapshot(serial)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if dst.Addr == 0 {
+		// return to home slot
+		dst = v.Home
+	}
+
+	if v.Location.Category != tape.TransferSlot {
+		return errors.E(op, errors.Strf("invalid source slot for unload operation"))
+	}
+
+	if dst.Category != tape.StorageSlot && dst.Category != tape.ImportExportSlot {
+		return errors.E(op, errors.Strf("invalid destination slot for unload operation"))
+	}
+
+	src := v.Location
+
+	txnID, err := p.journal.Begin(ctx, journal.OpUnload, serial, src, dst)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	// See LoadCtx: the changer call runs unlocked, between two separately
+	// committing transactions, so it doesn't hold bbolt's writer lock.
+	err = p.db.Update(func(tx *bbolt.Tx) error {
+		v, err := getVolume(tx, serial)
+		if err != nil {
+			return err
+		}
+
+		// snapshot validated an unlocked read; re-check against the row now
+		// that it's locked, in case a concurrent move changed it in between,
+		// and use this location - not the unlocked snapshot's - from here on.
+		if v.Location.Category != tape.TransferSlot {
+			return errors.Strf("invalid source slot for unload operation")
+		}
+
+		src = v.Location
+
+		bitmask.Clear(&v.Flags, tape.StatusMounted)
+		bitmask.Set(&v.Flags, tape.StatusTransfering)
+
+		return putVolume(tx, v)
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := changer.CallUnload(ctx, chgr, p.unloadTimeout, src, dst); err != nil {
+		return errors.E(op, err)
+	}
+
+	err = p.db.Update(func(tx *bbolt.Tx) error {
+		v, err := getVolume(tx, serial)
+		if err != nil {
+			return err
+		}
+
+		bitmask.Clear(&v.Flags, tape.StatusTransfering)
+
+		if err := deleteIndex(tx, src); err != nil {
+			return err
+		}
+
+		v.Location = dst
+		v.Home = tape.Location{}
+
+		if err := putIndex(tx, dst, v.Serial); err != nil {
+			return err
+		}
+
+		return putVolume(tx, v)
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := p.journal.Complete(ctx, txnID); err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+func (p *impl) Transfer(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	return p.TransferCtx(context.Background(), serial, dst, chgr)
+}
+
+// TransferCtx is Transfer, bounded by ctx. See LoadCtx for the recovery
+// implication of a context that expires mid-move.
+func (p *impl) TransferCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/bolt.Transfer"
+
+	v, err := p.snapshot(serial)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if v.Location.Category != tape.StorageSlot && v.Location.Category != tape.ImportExportSlot {
+		return errors.E(op, errors.Strf("invalid source slot for transfer operation"))
+	}
+
+	if dst.Category != tape.StorageSlot && dst.Category != tape.ImportExportSlot {
+		return errors.E(op, errors.Strf("invalid destination slot for transfer"))
+	}
+
+	src := v.Location
+
+	txnID, err := p.journal.Begin(ctx, journal.OpTransfer, serial, src, dst)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	// See LoadCtx: the changer call runs unlocked, between two separately
+	// committing transactions, so it doesn't hold bbolt's writer lock.
+	err = p.db.Update(func(tx *bbolt.Tx) error {
+		v, err := getVolume(tx, serial)
+		if err != nil {
+			return err
+		}
+
+		// snapshot validated an unlocked read; re-check against the row now
+		// that it's locked, in case a concurrent move changed it in between,
+		// and use this location - not the unlocked snapshot's - from here on.
+		if v.Location.Category != tape.StorageSlot && v.Location.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid source slot for transfer operation")
+		}
+
+		src = v.Location
+
+		bitmask.Set(&v.Flags, tape.StatusTransfering)
+
+		return putVolume(tx, v)
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := changer.CallTransfer(ctx, chgr, p.unloadTimeout, src, dst); err != nil {
+		return errors.E(op, err)
+	}
+
+	err = p.db.Update(func(tx *bbolt.Tx) error {
+		v, err := getVolume(tx, serial)
+		if err != nil {
+			return err
+		}
+
+		bitmask.Clear(&v.Flags, tape.StatusTransfering)
+
+		if err := deleteIndex(tx, src); err != nil {
+			return err
+		}
+
+		v.Location = dst
+
+		if err := putIndex(tx, dst, v.Serial); err != nil {
+			return err
+		}
+
+		return putVolume(tx, v)
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := p.journal.Complete(ctx, txnID); err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+func (p *impl) Loaded(loc tape.Location) (bool, tape.Serial, error) {
+	const op = "inv/bolt.Loaded"
+
+	var (
+		serial tape.Serial
+		found  bool
+	)
+
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketIndex).Get(indexKey(loc))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		serial = tape.Serial(data)
+
+		return nil
+	})
+	if err != nil {
+		return false, "", errors.E(op, err)
+	}
+
+	return found, serial, nil
+}
+
+func (p *impl) Info(serial tape.Serial) (tape.Volume, error) {
+	const op = "inv/bolt.Info"
+
+	var v tape.Volume
+
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		vv, err := getVolume(tx, serial)
+		if err != nil {
+			return err
+		}
+
+		v = vv
+
+		return nil
+	})
+	if err != nil {
+		return tape.Volume{}, errors.E(op, err)
+	}
+
+	return v, nil
+}
+
+func (p *impl) Update(vol tape.Volume) error {
+	const op = "inv/bolt.Update"
+
+	err := p.db.Update(func(tx *bbolt.Tx) error {
+		// Keep the index in sync for callers that use Update to change a
+		// volume's Location, the same way Load/Unload/Transfer do.
+		if existing, err := getVolume(tx, vol.Serial); err == nil && existing.Location != vol.Location {
+			if err := deleteIndex(tx, existing.Location); err != nil {
+				return err
+			}
+		}
+
+		if err := putIndex(tx, vol.Location, vol.Serial); err != nil {
+			return err
+		}
+
+		return putVolume(tx, vol)
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+func (p *impl) Alloc() (tape.Serial, error) {
+	return p.AllocCtx(context.Background())
+}
+
+// AllocCtx is Alloc. ctx is not used: the allocation is a single local bbolt
+// update with no changer call.
+func (p *impl) AllocCtx(_ context.Context) (tape.Serial, error) {
+	const op = "inv/bolt.Alloc"
+
+	var serial tape.Serial
+
+	err := p.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketVolumes)
+
+		var filling, scratch *tape.Volume
+
+		c := b.Cursor()
+		for k, data := c.First(); k != nil; k, data = c.Next() {
+			v, err := decodeVolume(data)
+			if err != nil {
+				return err
+			}
+
+			if v.Location.Category != tape.StorageSlot {
+				continue
+			}
+
+			switch v.Category {
+			case tape.Filling:
+				if filling == nil {
+					filling = &v
+				}
+			case tape.Scratch:
+				if scratch == nil {
+					scratch = &v
+				}
+			}
+		}
+
+		candidate := filling
+		if candidate == nil {
+			candidate = scratch
+		}
+
+		if candidate == nil {
+			return errors.Strf("no scratch or filling volume available")
+		}
+
+		serial = candidate.Serial
+
+		if candidate.Category != tape.Filling {
+			candidate.Category = tape.Allocating
+			return putVolume(tx, *candidate)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+
+	return serial, nil
+}
+
+// Reset drops and recreates all buckets, discarding any data.
+func (p *impl) Reset() error {
+	const op = "inv/bolt.Reset"
+
+	err := p.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range allBuckets {
+			if err := tx.DeleteBucket(name); err != nil && err != bbolt.ErrBucketNotFound {
+				return err
+			}
+
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+// Recover replays whatever journal entries a crash left open, reconciling
+// each against the changer's actual state.
+func (p *impl) Recover(ctx context.Context, chgr changer.Changer) error {
+	const op = "inv/bolt.Recover"
+
+	entries, err := p.journal.Open(ctx)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	for _, e := range entries {
+		if err := p.reconcile(ctx, chgr, e); err != nil {
+			return errors.E(op, errors.Strf("reconciling txn %s: %v", e.TxnID, err))
+		}
+	}
+
+	return nil
+}
+
+// reconcile asks the changer whether the move journaled by e actually
+// finished. If it did, the resolving DB update the crash interrupted is
+// replayed; if it didn't, the volume's logical location is rolled back to
+// where the journal entry says the move started.
+func (p *impl) reconcile(ctx context.Context, chgr changer.Changer, e journal.Entry) error {
+	slots, err := changer.CallStatus(ctx, chgr, p.statusTimeout)
+	if err != nil {
+		return err
+	}
+
+	finished := false
+
+	for _, slot := range slots[e.Dst.Category] {
+		if slot.Addr == e.Dst.Addr {
+			finished = slot.Volume != nil && slot.Volume.Serial == e.Serial
+			break
+		}
+	}
+
+	err = p.db.Update(func(tx *bbolt.Tx) error {
+		v, err := getVolume(tx, e.Serial)
+		if err != nil {
+			return err
+		}
+
+		bitmask.Clear(&v.Flags, tape.StatusTransfering)
+
+		loc := e.Src
+
+		if finished {
+			loc = e.Dst
+
+			switch e.Op {
+			case journal.OpLoad:
+				bitmask.Set(&v.Flags, tape.StatusMounted)
+
+				if v.Category == tape.Allocating {
+					v.Category = tape.Allocated
+				}
+			case journal.OpUnload:
+				bitmask.Clear(&v.Flags, tape.StatusMounted)
+				v.Home = tape.Location{}
+			case journal.OpTransfer:
+			}
+		} else if e.Op == journal.OpUnload {
+			// the volume never left the drive
+			bitmask.Set(&v.Flags, tape.StatusMounted)
+		}
+
+		if err := deleteIndex(tx, v.Location); err != nil {
+			return err
+		}
+
+		v.Location = loc
+
+		if err := putIndex(tx, loc, v.Serial); err != nil {
+			return err
+		}
+
+		return putVolume(tx, v)
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.journal.Complete(ctx, e.TxnID)
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv/journal"
+)
+
+var errTest = errors.New("changer failure")
+
+func newTestInventory(t *testing.T) *impl {
+	t.Helper()
+
+	i, err := New(map[string]string{
+		"path":            filepath.Join(t.TempDir(), "inventory.db"),
+		"cleaning-prefix": "CLN",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return i.(*impl)
+}
+
+func TestUpdateKeepsIndexInSync(t *testing.T) {
+	p := newTestInventory(t)
+
+	serial := tape.Serial("SERIAL1")
+	home := tape.Location{Addr: 1, Category: tape.StorageSlot}
+
+	if err := p.Update(tape.Volume{Serial: serial, Location: home, Home: home}); err != nil {
+		t.Fatalf("Update (create): %v", err)
+	}
+
+	loaded, got, err := p.Loaded(home)
+	if err != nil {
+		t.Fatalf("Loaded: %v", err)
+	}
+
+	if !loaded || got != serial {
+		t.Fatalf("Loaded(home) = (%v, %q), want (true, %q)", loaded, got, serial)
+	}
+
+	newLoc := tape.Location{Addr: 2, Category: tape.StorageSlot}
+
+	if err := p.Update(tape.Volume{Serial: serial, Location: newLoc, Home: home}); err != nil {
+		t.Fatalf("Update (move): %v", err)
+	}
+
+	if loaded, _, err := p.Loaded(home); err != nil {
+		t.Fatalf("Loaded(old): %v", err)
+	} else if loaded {
+		t.Error("old index entry still reports the volume loaded after Update moved it")
+	}
+
+	loaded, got, err = p.Loaded(newLoc)
+	if err != nil {
+		t.Fatalf("Loaded(new): %v", err)
+	}
+
+	if !loaded || got != serial {
+		t.Fatalf("Loaded(newLoc) = (%v, %q), want (true, %q)", loaded, got, serial)
+	}
+}
+
+type fakeChanger struct {
+	loadErr, unloadErr, transferErr error
+}
+
+func (c *fakeChanger) Load(context.Context, tape.Location, tape.Location) error     { return c.loadErr }
+func (c *fakeChanger) Unload(context.Context, tape.Location, tape.Location) error   { return c.unloadErr }
+func (c *fakeChanger) Transfer(context.Context, tape.Location, tape.Location) error { return c.transferErr }
+
+func (c *fakeChanger) Status(context.Context) (map[tape.SlotCategory][]changer.Slot, error) {
+	return nil, nil
+}
+
+var _ changer.Changer = (*fakeChanger)(nil)
+
+func TestLoadCtxMovesVolumeAndCompletesJournal(t *testing.T) {
+	p := newTestInventory(t)
+
+	serial := tape.Serial("SERIAL1")
+	src := tape.Location{Addr: 1, Category: tape.StorageSlot}
+	dst := tape.Location{Addr: 1, Category: tape.TransferSlot}
+
+	if err := p.Update(tape.Volume{Serial: serial, Location: src, Home: src}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	chgr := &fakeChanger{}
+
+	if err := p.LoadCtx(context.Background(), serial, dst, chgr); err != nil {
+		t.Fatalf("LoadCtx: %v", err)
+	}
+
+	v, err := p.Info(serial)
+	if err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+
+	if v.Location != dst {
+		t.Errorf("Location = %+v, want %+v", v.Location, dst)
+	}
+
+	entries, err := p.journal.Open(context.Background())
+	if err != nil {
+		t.Fatalf("journal.Open: %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("open journal entries after a successful LoadCtx = %d, want 0", len(entries))
+	}
+}
+
+func TestLoadCtxLeavesJournalEntryOnChangerFailure(t *testing.T) {
+	p := newTestInventory(t)
+
+	serial := tape.Serial("SERIAL1")
+	src := tape.Location{Addr: 1, Category: tape.StorageSlot}
+	dst := tape.Location{Addr: 1, Category: tape.TransferSlot}
+
+	if err := p.Update(tape.Volume{Serial: serial, Location: src, Home: src}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	chgr := &fakeChanger{loadErr: errTest}
+
+	if err := p.LoadCtx(context.Background(), serial, dst, chgr); err == nil {
+		t.Fatal("LoadCtx: expected error from changer, got nil")
+	}
+
+	entries, err := p.journal.Open(context.Background())
+	if err != nil {
+		t.Fatalf("journal.Open: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("open journal entries after a failed LoadCtx = %d, want 1", len(entries))
+	}
+
+	if entries[0].Serial != serial || entries[0].Op != journal.OpLoad {
+		t.Errorf("entry = %+v, want Serial %q Op OpLoad", entries[0], serial)
+	}
+}
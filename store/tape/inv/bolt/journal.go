@@ -0,0 +1,101 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv/journal"
+)
+
+// bucketJournal is the default journal.Journal for the bolt backend: a
+// "journal" bucket keyed by a sequence number, each value a gob-encoded
+// journal.Entry.
+type bucketJournal struct {
+	db *bbolt.DB
+}
+
+var _ journal.Journal = (*bucketJournal)(nil)
+
+func (j *bucketJournal) Begin(_ context.Context, op journal.Op, serial tape.Serial, src, dst tape.Location) (string, error) {
+	var txnID string
+
+	err := j.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketJournalName)
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		txnID = fmt.Sprintf("%d", seq)
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(journal.Entry{
+			TxnID: txnID, Op: op, Serial: serial, Src: src, Dst: dst, Ts: time.Now(),
+		}); err != nil {
+			return err
+		}
+
+		return b.Put(seqKey(seq), buf.Bytes())
+	})
+
+	return txnID, err
+}
+
+func (j *bucketJournal) Complete(_ context.Context, txnID string) error {
+	return j.db.Update(func(tx *bbolt.Tx) error {
+		var seq uint64
+		if _, err := fmt.Sscanf(txnID, "%d", &seq); err != nil {
+			return err
+		}
+
+		return tx.Bucket(bucketJournalName).Delete(seqKey(seq))
+	})
+}
+
+func (j *bucketJournal) Open(_ context.Context) ([]journal.Entry, error) {
+	var entries []journal.Entry
+
+	err := j.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketJournalName).ForEach(func(_, data []byte) error {
+			var e journal.Entry
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+				return err
+			}
+
+			entries = append(entries, e)
+
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+
+	return key
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+import (
+	"context"
+	"testing"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv/journal"
+)
+
+func TestBucketJournalBeginOpenComplete(t *testing.T) {
+	p := newTestInventory(t)
+
+	ctx := context.Background()
+
+	src := tape.Location{Addr: 1, Category: tape.StorageSlot}
+	dst := tape.Location{Addr: 2, Category: tape.TransferSlot}
+
+	txnID, err := p.journal.Begin(ctx, journal.OpLoad, "SERIAL1", src, dst)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	entries, err := p.journal.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(entries))
+	}
+
+	e := entries[0]
+	if e.TxnID != txnID || e.Op != journal.OpLoad || e.Serial != "SERIAL1" || e.Src != src || e.Dst != dst {
+		t.Errorf("entry = %+v, want matching Begin args (txnID %q)", e, txnID)
+	}
+
+	if err := p.journal.Complete(ctx, txnID); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	entries, err = p.journal.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open after Complete: %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("len(entries) after Complete = %d, want 0", len(entries))
+	}
+}
+
+func TestBucketJournalMultipleOpenEntries(t *testing.T) {
+	p := newTestInventory(t)
+
+	ctx := context.Background()
+
+	txnA, err := p.journal.Begin(ctx, journal.OpLoad, "A", tape.Location{Addr: 1}, tape.Location{Addr: 2})
+	if err != nil {
+		t.Fatalf("Begin A: %v", err)
+	}
+
+	txnB, err := p.journal.Begin(ctx, journal.OpUnload, "B", tape.Location{Addr: 3}, tape.Location{Addr: 4})
+	if err != nil {
+		t.Fatalf("Begin B: %v", err)
+	}
+
+	if err := p.journal.Complete(ctx, txnA); err != nil {
+		t.Fatalf("Complete A: %v", err)
+	}
+
+	entries, err := p.journal.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].TxnID != txnB {
+		t.Errorf("entries = %+v, want only txn %q still open", entries, txnB)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv
+
+import (
+	"context"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+)
+
+// ContextualInventory is implemented by Inventory backends that can bound
+// Load, Unload, Transfer and Alloc by a context.Context, so that a caller can
+// give up on a stuck changer instead of blocking on it indefinitely.
+//
+// For one release, Inventory's plain Load/Unload/Transfer/Alloc methods
+// remain and simply call through to these with context.Background(); callers
+// that need a deadline should switch to the *Ctx methods directly.
+type ContextualInventory interface {
+	LoadCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error
+	UnloadCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error
+	TransferCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error
+	AllocCtx(ctx context.Context) (tape.Serial, error)
+}
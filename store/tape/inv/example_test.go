@@ -0,0 +1,59 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+	"tapr.space/store/tape/inv/bolt"
+)
+
+// ExampleRecover shows the call every caller that wires up an Inventory
+// backend together with its changer should make once, right after
+// construction and before serving any requests, so a crash during a
+// previous Load/Unload/Transfer is reconciled before the changer is handed
+// new work.
+func ExampleRecover() {
+	dir, err := os.MkdirTemp("", "tapr-inv-example")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	i, err := bolt.New(map[string]string{
+		"path":            filepath.Join(dir, "inventory.db"),
+		"cleaning-prefix": "CLN",
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var chgr changer.Changer // the Changer driving the library's real media changer
+
+	if err := inv.Recover(context.Background(), i, chgr); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("recovered")
+	// Output: recovered
+}
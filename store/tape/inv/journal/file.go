@@ -0,0 +1,142 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+// record is the on-disk representation of either a Begin (Complete == false)
+// or a Complete (Complete == true, everything but TxnID zero) call.
+type record struct {
+	TxnID    string        `json:"txn_id"`
+	Op       Op            `json:"op,omitempty"`
+	Serial   tape.Serial   `json:"serial,omitempty"`
+	Src      tape.Location `json:"src,omitempty"`
+	Dst      tape.Location `json:"dst,omitempty"`
+	Ts       time.Time     `json:"ts,omitempty"`
+	Complete bool          `json:"complete,omitempty"`
+}
+
+// FileJournal is a Journal backed by an append-only, newline-delimited JSON
+// file: every Begin and Complete fsyncs before returning, so a Begin record is durable
+// before the changer is ever invoked.
+type FileJournal struct {
+	mu   sync.Mutex
+	path string
+}
+
+var _ Journal = (*FileJournal)(nil)
+
+// NewFile returns a FileJournal backed by the file at path, creating it if it
+// doesn't already exist.
+func NewFile(path string) (*FileJournal, error) {
+	const op = "inv/journal.NewFile"
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return &FileJournal{path: path}, nil
+}
+
+func (j *FileJournal) append(r record) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func (j *FileJournal) Begin(_ context.Context, op Op, serial tape.Serial, src, dst tape.Location) (string, error) {
+	txnID := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	err := j.append(record{TxnID: txnID, Op: op, Serial: serial, Src: src, Dst: dst, Ts: time.Now()})
+
+	return txnID, err
+}
+
+func (j *FileJournal) Complete(_ context.Context, txnID string) error {
+	return j.append(record{TxnID: txnID, Complete: true})
+}
+
+func (j *FileJournal) Open(_ context.Context) ([]Entry, error) {
+	const op = "inv/journal.Open"
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.Open(j.path)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	defer f.Close()
+
+	open := map[string]Entry{}
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		if r.Complete {
+			delete(open, r.TxnID)
+			continue
+		}
+
+		open[r.TxnID] = Entry{TxnID: r.TxnID, Op: r.Op, Serial: r.Serial, Src: r.Src, Dst: r.Dst, Ts: r.Ts}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	entries := make([]Entry, 0, len(open))
+	for _, e := range open {
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
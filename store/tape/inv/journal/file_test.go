@@ -0,0 +1,129 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"tapr.space/store/tape"
+)
+
+func TestFileJournalBeginOpenComplete(t *testing.T) {
+	ctx := context.Background()
+
+	j, err := NewFile(filepath.Join(t.TempDir(), "journal.log"))
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+
+	src := tape.Location{Addr: 1, Category: tape.StorageSlot}
+	dst := tape.Location{Addr: 2, Category: tape.TransferSlot}
+
+	txnID, err := j.Begin(ctx, OpLoad, "SERIAL1", src, dst)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	entries, err := j.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(entries))
+	}
+
+	e := entries[0]
+	if e.TxnID != txnID || e.Op != OpLoad || e.Serial != "SERIAL1" || e.Src != src || e.Dst != dst {
+		t.Errorf("entry = %+v, want matching Begin args (txnID %q)", e, txnID)
+	}
+
+	if err := j.Complete(ctx, txnID); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	entries, err = j.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open after Complete: %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("len(entries) after Complete = %d, want 0", len(entries))
+	}
+}
+
+func TestFileJournalMultipleOpenEntries(t *testing.T) {
+	ctx := context.Background()
+
+	j, err := NewFile(filepath.Join(t.TempDir(), "journal.log"))
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+
+	txnA, err := j.Begin(ctx, OpLoad, "A", tape.Location{Addr: 1}, tape.Location{Addr: 2})
+	if err != nil {
+		t.Fatalf("Begin A: %v", err)
+	}
+
+	txnB, err := j.Begin(ctx, OpUnload, "B", tape.Location{Addr: 3}, tape.Location{Addr: 4})
+	if err != nil {
+		t.Fatalf("Begin B: %v", err)
+	}
+
+	if err := j.Complete(ctx, txnA); err != nil {
+		t.Fatalf("Complete A: %v", err)
+	}
+
+	entries, err := j.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].TxnID != txnB {
+		t.Errorf("entries = %+v, want only txn %q still open", entries, txnB)
+	}
+}
+
+func TestFileJournalPersistsAcrossReopen(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "journal.log")
+
+	j1, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+
+	txnID, err := j1.Begin(ctx, OpTransfer, "SERIAL1", tape.Location{Addr: 1}, tape.Location{Addr: 2})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	j2, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile (reopen): %v", err)
+	}
+
+	entries, err := j2.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open (reopen): %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].TxnID != txnID {
+		t.Errorf("entries after reopen = %+v, want txn %q", entries, txnID)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journal records in-flight Load/Unload/Transfer operations so that a
+// crash between the "in flight" DB commit and the DB commit that resolves the
+// changer's result can be reconciled on the next startup, instead of leaving
+// a volume's location permanently NULL. A backend writes an entry before
+// invoking the changer and marks it complete once the resolving DB update has
+// committed; on startup it replays whatever entries were never completed.
+package journal // import "tapr.space/store/tape/inv/journal"
+
+import (
+	"context"
+	"time"
+
+	"tapr.space/store/tape"
+)
+
+// Op identifies which inv.Inventory method an Entry belongs to.
+type Op int
+
+// Known ops.
+const (
+	OpLoad Op = iota
+	OpUnload
+	OpTransfer
+)
+
+// String implements fmt.Stringer.
+func (op Op) String() string {
+	switch op {
+	case OpLoad:
+		return "load"
+	case OpUnload:
+		return "unload"
+	case OpTransfer:
+		return "transfer"
+	}
+
+	panic("unknown op")
+}
+
+// An Entry records a single in-flight move: serial was being moved from Src
+// to Dst by the operation named Op, starting at Ts.
+type Entry struct {
+	TxnID  string
+	Op     Op
+	Serial tape.Serial
+	Src    tape.Location
+	Dst    tape.Location
+	Ts     time.Time
+}
+
+// A Journal is a pluggable, append-only log of in-flight operations. Backends
+// implement it over whatever storage they already have: a file, a table in
+// their own database, or a bucket in an embedded KV store.
+type Journal interface {
+	// Begin durably records that op is about to move serial from src to dst,
+	// before the changer is invoked, and returns a transaction id that must be
+	// passed to Complete once the DB update resolving the move has committed.
+	Begin(ctx context.Context, op Op, serial tape.Serial, src, dst tape.Location) (txnID string, err error)
+
+	// Complete marks txnID as finished; Open will no longer return it.
+	Complete(ctx context.Context, txnID string) error
+
+	// Open returns the entries that were Begin'd but never Complete'd -- the
+	// set a Recover call must reconcile.
+	Open(ctx context.Context) ([]Entry, error)
+}
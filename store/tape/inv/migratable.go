@@ -0,0 +1,36 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv
+
+import "context"
+
+// Migratable is implemented by Inventory backends whose schema is managed by
+// versioned migrations (see store/tape/inv/migrate) rather than a single
+// destructive Reset. Backends that don't support migrations simply don't
+// implement it; callers should type-assert an Inventory to Migratable before
+// relying on it.
+type Migratable interface {
+	// Migrate brings the schema to target, running whichever up or down
+	// migrations are needed to get there.
+	Migrate(ctx context.Context, target int) error
+
+	// Version reports the currently applied schema version.
+	Version(ctx context.Context) (int, error)
+
+	// Force sets the recorded schema version without running migrations. It is
+	// meant for recovering from drift once an operator has confirmed what
+	// state the schema is actually in.
+	Force(ctx context.Context, version int) error
+}
@@ -0,0 +1,290 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package migrate manages numbered up/down SQL schema migrations for inv
+// backends. It tracks the applied version in a schema_migrations table so a
+// backend's New can bring an existing database forward without dropping data.
+package migrate // import "tapr.space/store/tape/inv/migrate"
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tapr.space/errors"
+)
+
+// A Migration is a single numbered schema change, expressed as forward (Up)
+// and backward (Down) SQL.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Load reads migrations out of fsys, which is expected to hold pairs of files
+// named "NNNN_name.up.sql" and "NNNN_name.down.sql", and returns them sorted
+// by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	const op = "inv/migrate.Load"
+
+	byVersion := map[int]*Migration{}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	for _, e := range entries {
+		version, name, dir, ok := parseFilename(e.Name())
+		if !ok {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		switch dir {
+		case "up":
+			m.Up = string(data)
+			m.Checksum = fmt.Sprintf("%x", sha256.Sum256(data))
+		case "down":
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func parseFilename(filename string) (version int, name, dir string, ok bool) {
+	switch {
+	case strings.HasSuffix(filename, ".up.sql"):
+		dir = "up"
+		filename = strings.TrimSuffix(filename, ".up.sql")
+	case strings.HasSuffix(filename, ".down.sql"):
+		dir = "down"
+		filename = strings.TrimSuffix(filename, ".down.sql")
+	default:
+		return 0, "", "", false
+	}
+
+	parts := strings.SplitN(filename, "_", 2)
+	if len(parts) != 2 {
+		return 0, "", "", false
+	}
+
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", "", false
+	}
+
+	return version, parts[1], dir, true
+}
+
+const createSchemaMigrations = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		checksum   TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)
+`
+
+// A Migrator applies and tracks Migrations against a *sql.DB, recording the
+// applied version (and a checksum of its Up script, for drift detection) in a
+// schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+
+	// TransactionalDDL reports whether the dialect can run schema changes
+	// inside a transaction. When true (postgres), a migration's DDL and its
+	// schema_migrations bookkeeping commit atomically; when false they're
+	// applied as separate statements and a failure between them needs Force to
+	// recover from.
+	TransactionalDDL bool
+}
+
+// New returns a Migrator that applies migrations against db.
+func New(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations, TransactionalDDL: true}
+}
+
+// Head returns the highest version among the Migrator's migrations.
+func (m *Migrator) Head() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, createSchemaMigrations)
+	return err
+}
+
+// Version returns the currently applied schema version, or 0 if no migration
+// has been applied yet.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	const op = "inv/migrate.Version"
+
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, errors.E(op, err)
+	}
+
+	var version int
+
+	stmt := `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+	if err := m.db.QueryRowContext(ctx, stmt).Scan(&version); err != nil {
+		return 0, errors.E(op, err)
+	}
+
+	return version, nil
+}
+
+// Migrate brings the schema to target, running whichever up or down
+// migrations lie between the current version and target.
+func (m *Migrator) Migrate(ctx context.Context, target int) error {
+	const op = "inv/migrate.Migrate"
+
+	current, err := m.Version(ctx)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	switch {
+	case target > current:
+		for _, mig := range m.migrations {
+			if mig.Version <= current || mig.Version > target {
+				continue
+			}
+
+			if err := m.step(ctx, mig.Up, mig.Version, mig.Checksum, true); err != nil {
+				return errors.E(op, errors.Strf("applying migration %04d_%s: %v", mig.Version, mig.Name, err))
+			}
+		}
+	case target < current:
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if mig.Version > current || mig.Version <= target {
+				continue
+			}
+
+			if err := m.step(ctx, mig.Down, mig.Version, mig.Checksum, false); err != nil {
+				return errors.E(op, errors.Strf("reverting migration %04d_%s: %v", mig.Version, mig.Name, err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// step applies a single migration's DDL and its schema_migrations bookkeeping,
+// as one transaction when TransactionalDDL is set.
+func (m *Migrator) step(ctx context.Context, ddl string, version int, checksum string, up bool) error {
+	bookkeep := func(exec func(string, ...interface{}) error) error {
+		if up {
+			return exec(`INSERT INTO schema_migrations (version, checksum) VALUES ($1, $2)`, version, checksum)
+		}
+
+		return exec(`DELETE FROM schema_migrations WHERE version = $1`, version)
+	}
+
+	if !m.TransactionalDDL {
+		if _, err := m.db.ExecContext(ctx, ddl); err != nil {
+			return err
+		}
+
+		return bookkeep(func(stmt string, args ...interface{}) error {
+			_, err := m.db.ExecContext(ctx, stmt, args...)
+			return err
+		})
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, ddl); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := bookkeep(func(stmt string, args ...interface{}) error {
+		_, err := tx.ExecContext(ctx, stmt, args...)
+		return err
+	}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Force sets the recorded schema version without running any migrations. It
+// is meant for recovering from a non-transactional migration that applied its
+// DDL but failed to record it (or vice versa), once an operator has confirmed
+// what state the database is actually in.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	const op = "inv/migrate.Force"
+
+	if err := m.ensureTable(ctx); err != nil {
+		return errors.E(op, err)
+	}
+
+	if _, err := m.db.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version > $1`, version); err != nil {
+		return errors.E(op, err)
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version > version {
+			break
+		}
+
+		stmt := `
+			INSERT INTO schema_migrations (version, checksum)
+			VALUES ($1, $2)
+			ON CONFLICT (version) DO UPDATE SET checksum = $2
+		`
+
+		if _, err := m.db.ExecContext(ctx, stmt, mig.Version, mig.Checksum); err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseFilename(t *testing.T) {
+	cases := []struct {
+		filename    string
+		wantVersion int
+		wantName    string
+		wantDir     string
+		wantOK      bool
+	}{
+		{"0001_create_volumes.up.sql", 1, "create_volumes", "up", true},
+		{"0001_create_volumes.down.sql", 1, "create_volumes", "down", true},
+		{"0012_add_index_to_volumes.up.sql", 12, "add_index_to_volumes", "up", true},
+		{"README.md", 0, "", "", false},
+		{"0001.up.sql", 0, "", "", false},
+		{"abcd_name.up.sql", 0, "", "", false},
+	}
+
+	for _, c := range cases {
+		version, name, dir, ok := parseFilename(c.filename)
+		if ok != c.wantOK {
+			t.Errorf("parseFilename(%q) ok = %v, want %v", c.filename, ok, c.wantOK)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		if version != c.wantVersion || name != c.wantName || dir != c.wantDir {
+			t.Errorf("parseFilename(%q) = (%d, %q, %q), want (%d, %q, %q)",
+				c.filename, version, name, dir, c.wantVersion, c.wantName, c.wantDir)
+		}
+	}
+}
+
+func TestLoadSortsByVersionAndPairsUpDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_home.up.sql":       {Data: []byte("ALTER TABLE volumes ADD COLUMN home integer;")},
+		"0002_add_home.down.sql":     {Data: []byte("ALTER TABLE volumes DROP COLUMN home;")},
+		"0001_create_volumes.up.sql": {Data: []byte("CREATE TABLE volumes (serial text);")},
+		"0001_create_volumes.down.sql": {
+			Data: []byte("DROP TABLE volumes;"),
+		},
+		"notes.txt": {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("len(migrations) = %d, want 2", len(migrations))
+	}
+
+	if migrations[0].Version != 1 || migrations[1].Version != 2 {
+		t.Fatalf("migrations not sorted by version: %+v", migrations)
+	}
+
+	if migrations[0].Up == "" || migrations[0].Down == "" {
+		t.Errorf("migration 1 missing up or down SQL: %+v", migrations[0])
+	}
+
+	if migrations[0].Checksum == "" {
+		t.Error("migration 1 missing checksum")
+	}
+}
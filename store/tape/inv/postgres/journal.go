@@ -0,0 +1,105 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv/journal"
+)
+
+// tableJournal is the default journal.Journal for the postgres backend: a
+// "journal" table in the same database, managed by migration 0002.
+type tableJournal struct {
+	db *sqlx.DB
+}
+
+var _ journal.Journal = (*tableJournal)(nil)
+
+func (j *tableJournal) Begin(ctx context.Context, op journal.Op, serial tape.Serial, src, dst tape.Location) (string, error) {
+	var txnID string
+
+	stmt := `
+		INSERT INTO journal (op, serial, src, dst)
+		VALUES ($1, $2, ($3, $4), ($5, $6))
+		RETURNING seq::text
+	`
+
+	err := j.db.GetContext(ctx, &txnID, stmt,
+		op.String(), serial, src.Addr, src.Category, dst.Addr, dst.Category,
+	)
+
+	return txnID, err
+}
+
+func (j *tableJournal) Complete(ctx context.Context, txnID string) error {
+	_, err := j.db.ExecContext(ctx, `UPDATE journal SET done = true WHERE seq::text = $1`, txnID)
+	return err
+}
+
+type journalRow struct {
+	TxnID  string        `db:"txn_id"`
+	Op     string        `db:"op"`
+	Serial tape.Serial   `db:"serial"`
+	Src    tape.Location `db:"src"`
+	Dst    tape.Location `db:"dst"`
+	Ts     time.Time     `db:"ts"`
+}
+
+func (j *tableJournal) Open(ctx context.Context) ([]journal.Entry, error) {
+	var rows []journalRow
+
+	stmt := `
+		SELECT seq::text AS txn_id, op, serial, src, dst, ts
+		FROM journal
+		WHERE NOT done
+		ORDER BY seq
+	`
+
+	if err := j.db.SelectContext(ctx, &rows, stmt); err != nil {
+		return nil, err
+	}
+
+	entries := make([]journal.Entry, len(rows))
+	for i, r := range rows {
+		entries[i] = journal.Entry{
+			TxnID:  r.TxnID,
+			Op:     parseOp(r.Op),
+			Serial: r.Serial,
+			Src:    r.Src,
+			Dst:    r.Dst,
+			Ts:     r.Ts,
+		}
+	}
+
+	return entries, nil
+}
+
+func parseOp(s string) journal.Op {
+	switch s {
+	case "load":
+		return journal.OpLoad
+	case "unload":
+		return journal.OpUnload
+	case "transfer":
+		return journal.OpTransfer
+	}
+
+	panic("inv/postgres: unknown journal op " + s)
+}
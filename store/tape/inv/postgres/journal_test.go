@@ -0,0 +1,48 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"testing"
+
+	"tapr.space/store/tape/inv/journal"
+)
+
+func TestParseOp(t *testing.T) {
+	cases := []struct {
+		s    string
+		want journal.Op
+	}{
+		{"load", journal.OpLoad},
+		{"unload", journal.OpUnload},
+		{"transfer", journal.OpTransfer},
+	}
+
+	for _, c := range cases {
+		if got := parseOp(c.s); got != c.want {
+			t.Errorf("parseOp(%q) = %v, want %v", c.s, got, c.want)
+		}
+	}
+}
+
+func TestParseOpPanicsOnUnknown(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("parseOp(\"bogus\") did not panic")
+		}
+	}()
+
+	parseOp("bogus")
+}
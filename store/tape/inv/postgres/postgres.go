@@ -16,10 +16,12 @@
 package postgres // import "tapr.space/store/tape/inv/postgres"
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // side-effect: register postgresql driver
@@ -31,30 +33,14 @@ import (
 	"tapr.space/store/tape"
 	"tapr.space/store/tape/changer"
 	"tapr.space/store/tape/inv"
+	"tapr.space/store/tape/inv/journal"
+	"tapr.space/store/tape/inv/migrate"
 )
 
 func init() {
 	inv.Register("postgres", New)
 }
 
-func rollback(op string, tx *sqlx.Tx, err error) error {
-	log.Error.Printf("%s: transaction roll back due to error: %v", op, err)
-	if err := tx.Rollback(); err != nil {
-		log.Error.Printf("%s: could not roll back transaction: %v", op, err)
-	}
-
-	return err
-}
-
-func commit(op string, tx *sqlx.Tx) error {
-	if err := tx.Commit(); err != nil {
-		log.Error.Printf("%s: could not commit: %v", op, err)
-		return err
-	}
-
-	return nil
-}
-
 type rvol struct {
 	Serial   tape.Serial         `db:"serial"`
 	Location tape.Location       `db:"location"`
@@ -64,14 +50,24 @@ type rvol struct {
 }
 
 type postgres struct {
-	db *sqlx.DB
+	db       *sqlx.DB
+	tr       inv.Transactor
+	migrator *migrate.Migrator
+	journal  journal.Journal
 
 	mu sync.Mutex
 
 	prefixCleaning string
+
+	loadTimeout   time.Duration
+	unloadTimeout time.Duration
+	statusTimeout time.Duration
 }
 
 var _ inv.Inventory = (*postgres)(nil)
+var _ inv.Migratable = (*postgres)(nil)
+var _ inv.Recoverable = (*postgres)(nil)
+var _ inv.ContextualInventory = (*postgres)(nil)
 
 // New returns a new postgres-backed inventory implementation.
 func New(opts map[string]string) (inv.Inventory, error) {
@@ -96,12 +92,84 @@ func New(opts map[string]string) (inv.Inventory, error) {
 		return nil, err
 	}
 
+	migrations, err := migrate.Load(migrationsFS)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	migrator := migrate.New(db.DB, migrations)
+
+	if opts["automigrate"] == "true" {
+		if err := migrator.Migrate(context.Background(), migrator.Head()); err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	var jrnl journal.Journal
+
+	if path := opts["journal-path"]; path != "" {
+		jrnl, err = journal.NewFile(path)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	} else {
+		jrnl = &tableJournal{db: db}
+	}
+
+	loadTimeout, err := parseTimeout(opts, "load-timeout")
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	unloadTimeout, err := parseTimeout(opts, "unload-timeout")
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	statusTimeout, err := parseTimeout(opts, "status-timeout")
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
 	return &postgres{
 		db:             db,
+		tr:             &transactor{db: db, policy: inv.DefaultRetryPolicy},
+		migrator:       migrator,
+		journal:        jrnl,
 		prefixCleaning: opts["cleaning-prefix"],
+		loadTimeout:    loadTimeout,
+		unloadTimeout:  unloadTimeout,
+		statusTimeout:  statusTimeout,
 	}, nil
 }
 
+// parseTimeout parses the named opt as a time.Duration, e.g. "30s". An
+// unset or empty opt yields a zero duration, meaning "no deadline".
+func parseTimeout(opts map[string]string, name string) (time.Duration, error) {
+	s := opts[name]
+	if s == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// Migrate brings the schema to target, running whichever up or down
+// migrations are needed to get there.
+func (p *postgres) Migrate(ctx context.Context, target int) error {
+	return p.migrator.Migrate(ctx, target)
+}
+
+// Version reports the currently applied schema version.
+func (p *postgres) Version(ctx context.Context) (int, error) {
+	return p.migrator.Version(ctx)
+}
+
+// Force sets the recorded schema version without running migrations.
+func (p *postgres) Force(ctx context.Context, version int) error {
+	return p.migrator.Force(ctx, version)
+}
+
 func (p *postgres) Volumes() (vs []tape.Volume, err error) {
 	var rs []rvol
 
@@ -129,7 +197,7 @@ func (p *postgres) Volumes() (vs []tape.Volume, err error) {
 }
 
 func (p *postgres) Audit(chgr changer.Changer) (err error) {
-	slots, err := chgr.Status()
+	slots, err := changer.CallStatus(context.Background(), chgr, p.statusTimeout)
 	if err != nil {
 		return err
 	}
@@ -212,27 +280,21 @@ func (p *postgres) Lookup(path tapr.PathName) (tape.Volume, error) {
 }
 
 func (p *postgres) Load(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
-	const op = "inv/postgres.Load"
+	return p.LoadCtx(context.Background(), serial, dst, chgr)
+}
 
-	var r rvol
+// LoadCtx is Load, bounded by ctx. If ctx is cancelled or its deadline fires
+// while the changer is moving the tape, the load is left journaled for
+// Recover to reconcile rather than resolved here.
+func (p *postgres) LoadCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/postgres.Load"
 
-	tx, err := p.db.Beginx()
+	peek, err := p.Info(serial)
 	if err != nil {
-		return err
-	}
-
-	stmt := `
-		SELECT serial, location, home, category, flags
-		FROM volumes
-		WHERE serial = $1
-		FOR UPDATE
-	`
-
-	if err := tx.Get(&r, stmt, serial); err != nil {
-		return rollback(op, tx, err)
+		return errors.E(op, err)
 	}
 
-	if r.Location.Category != tape.StorageSlot && r.Location.Category != tape.ImportExportSlot {
+	if peek.Location.Category != tape.StorageSlot && peek.Location.Category != tape.ImportExportSlot {
 		return errors.E(op, errors.Strf("invalid source slot for load operation"))
 	}
 
@@ -240,79 +302,86 @@ func (p *postgres) Load(serial tape.Serial, dst tape.Location, chgr changer.Chan
 		return errors.E(op, errors.Strf("invalid destination slot for load operation"))
 	}
 
-	bitmask.Set(&r.Flags, tape.StatusTransfering)
-	bitmask.Set(&r.Flags, tape.StatusMounted)
-
-	stmt = `
-		UPDATE volumes
-		SET
-			location = NULL,
-			home = ($1, $2),
-			flags = $3
-		WHERE serial = $4
-	`
-
-	_, err = tx.Exec(stmt, r.Location.Addr, r.Location.Category, fmt.Sprintf("%b", r.Flags), r.Serial)
+	// Begin commits before the in-flight DB update below, so a crash in the
+	// window between the two still leaves Recover something to reconcile.
+	txnID, err := p.journal.Begin(ctx, journal.OpLoad, serial, peek.Location, dst)
 	if err != nil {
-		return rollback(op, tx, err)
+		return errors.E(op, err)
 	}
 
-	if err := commit(op, tx); err != nil {
+	v, err := p.tr.Transact(ctx, func(txi inv.Tx) (interface{}, error) {
+		t := txi.(*tx)
+
+		r, err := t.lock(serial)
+		if err != nil {
+			return nil, err
+		}
+
+		// peek validated an unlocked read; re-check against the row now that
+		// it's locked, in case a concurrent move changed it in between.
+		if r.Location.Category != tape.StorageSlot && r.Location.Category != tape.ImportExportSlot {
+			return nil, errors.E(op, errors.Strf("invalid source slot for load operation"))
+		}
+
+		bitmask.Set(&r.Flags, tape.StatusTransfering)
+		bitmask.Set(&r.Flags, tape.StatusMounted)
+
+		if err := t.setHome(r.Serial, r.Location); err != nil {
+			return nil, err
+		}
+
+		if err := t.markTransit(r); err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+	if err != nil {
 		return err
 	}
 
-	if err := chgr.Load(r.Location, dst); err != nil {
+	r := v.(rvol)
+
+	if err := changer.CallLoad(ctx, chgr, p.loadTimeout, r.Location, dst); err != nil {
 		return err
 	}
 
 	bitmask.Clear(&r.Flags, tape.StatusTransfering)
 
-	stmt = `
-		UPDATE volumes
-		SET
-			location = ($1, $2),
-			category = $3,
-			flags = $4
-		WHERE serial = $5
-	`
-
 	if r.Category == tape.Allocating {
 		r.Category = tape.Allocated
 	}
 
-	if _, err := p.db.Exec(stmt, dst.Addr, dst.Category, r.Category, fmt.Sprintf("%b", r.Flags), r.Serial); err != nil {
+	_, err = p.tr.Transact(ctx, func(txi inv.Tx) (interface{}, error) {
+		return nil, txi.(*tx).resolve(r, dst)
+	})
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return p.journal.Complete(ctx, txnID)
 }
 
 func (p *postgres) Unload(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
-	const op = "inv/postgres.Unload"
+	return p.UnloadCtx(context.Background(), serial, dst, chgr)
+}
 
-	var r rvol
+// UnloadCtx is Unload, bounded by ctx. See LoadCtx for the recovery
+// implication of a context that expires mid-move.
+func (p *postgres) UnloadCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/postgres.Unload"
 
-	tx, err := p.db.Beginx()
+	peek, err := p.Info(serial)
 	if err != nil {
-		return err
-	}
-
-	stmt := `
-		SELECT serial, location, home, category, flags
-		FROM volumes
-		WHERE serial = $1
-	`
-
-	if err := tx.Get(&r, stmt, serial); err != nil {
-		return rollback(op, tx, err)
+		return errors.E(op, err)
 	}
 
 	if dst.Addr == 0 {
 		// return to home slot
-		dst = r.Home
+		dst = peek.Home
 	}
 
-	if r.Location.Category != tape.TransferSlot {
+	if peek.Location.Category != tape.TransferSlot {
 		return errors.E(op, errors.Strf("invalid source slot for unload operation"))
 	}
 
@@ -320,69 +389,79 @@ func (p *postgres) Unload(serial tape.Serial, dst tape.Location, chgr changer.Ch
 		return errors.E(op, errors.Strf("invalid destination slot for unload operation"))
 	}
 
-	bitmask.Clear(&r.Flags, tape.StatusMounted)
-	bitmask.Set(&r.Flags, tape.StatusTransfering)
-
-	stmt = `
-		UPDATE volumes
-		SET
-			location = NULL,
-			flags = $1
-		WHERE serial = $2
-	`
-
-	_, err = tx.Exec(stmt, fmt.Sprintf("%b", r.Flags), r.Serial)
+	// Begin commits before the in-flight DB update below, so a crash in the
+	// window between the two still leaves Recover something to reconcile.
+	txnID, err := p.journal.Begin(ctx, journal.OpUnload, serial, peek.Location, dst)
 	if err != nil {
-		return rollback(op, tx, err)
+		return errors.E(op, err)
 	}
 
-	if err := commit(op, tx); err != nil {
+	v, err := p.tr.Transact(ctx, func(txi inv.Tx) (interface{}, error) {
+		t := txi.(*tx)
+
+		r, err := t.lock(serial)
+		if err != nil {
+			return nil, err
+		}
+
+		// peek validated an unlocked read; re-check against the row now that
+		// it's locked, in case a concurrent move changed it in between.
+		if r.Location.Category != tape.TransferSlot {
+			return nil, errors.E(op, errors.Strf("invalid source slot for unload operation"))
+		}
+
+		bitmask.Clear(&r.Flags, tape.StatusMounted)
+		bitmask.Set(&r.Flags, tape.StatusTransfering)
+
+		if err := t.markTransit(r); err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+	if err != nil {
 		return err
 	}
 
-	if err := chgr.Unload(r.Location, dst); err != nil {
+	r := v.(rvol)
+
+	if err := changer.CallUnload(ctx, chgr, p.unloadTimeout, r.Location, dst); err != nil {
 		return err
 	}
 
 	bitmask.Clear(&r.Flags, tape.StatusTransfering)
 
-	stmt = `
-		UPDATE volumes
-		SET
-			location = $1,
-			home = NULL,
-			flags = $2
-		WHERE serial = $3
-	`
+	_, err = p.tr.Transact(ctx, func(txi inv.Tx) (interface{}, error) {
+		t := txi.(*tx)
+
+		if err := t.resolve(r, dst); err != nil {
+			return nil, err
+		}
 
-	if _, err := tx.Exec(stmt, r.Flags, r.Serial); err != nil {
+		return nil, t.clearHome(r.Serial)
+	})
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return p.journal.Complete(ctx, txnID)
 }
 
 func (p *postgres) Transfer(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
-	const op = "inv/postgres.Transfer"
+	return p.TransferCtx(context.Background(), serial, dst, chgr)
+}
 
-	var r rvol
+// TransferCtx is Transfer, bounded by ctx. See LoadCtx for the recovery
+// implication of a context that expires mid-move.
+func (p *postgres) TransferCtx(ctx context.Context, serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/postgres.Transfer"
 
-	tx, err := p.db.Beginx()
+	peek, err := p.Info(serial)
 	if err != nil {
-		return err
+		return errors.E(op, err)
 	}
 
-	stmt := `
-		SELECT serial, location, home, category, flags
-		FROM volumes
-		WHERE serial = $1
-	`
-
-	if err := tx.Get(&r, stmt, serial); err != nil {
-		return rollback(op, tx, err)
-	}
-
-	if r.Location.Category != tape.StorageSlot && r.Location.Category != tape.ImportExportSlot {
+	if peek.Location.Category != tape.StorageSlot && peek.Location.Category != tape.ImportExportSlot {
 		return errors.E(op, errors.Strf("invalid source slot for transfer operation"))
 	}
 
@@ -390,45 +469,56 @@ func (p *postgres) Transfer(serial tape.Serial, dst tape.Location, chgr changer.
 		return errors.E(op, errors.Strf("invalid destination slot for transfer"))
 	}
 
-	// set transfering flag
-	bitmask.Set(&r.Flags, tape.StatusTransfering)
-
-	stmt = `
-		UPDATE volumes
-		SET
-			location = NULL,
-			flags = $3
-		WHERE serial = $4
-	`
-
-	_, err = tx.Exec(stmt, r.Location, r.Flags, r.Serial)
+	// Begin commits before the in-flight DB update below, so a crash in the
+	// window between the two still leaves Recover something to reconcile.
+	txnID, err := p.journal.Begin(ctx, journal.OpTransfer, serial, peek.Location, dst)
 	if err != nil {
-		return rollback(op, tx, err)
+		return errors.E(op, err)
 	}
 
-	if err := commit(op, tx); err != nil {
+	v, err := p.tr.Transact(ctx, func(txi inv.Tx) (interface{}, error) {
+		t := txi.(*tx)
+
+		r, err := t.lock(serial)
+		if err != nil {
+			return nil, err
+		}
+
+		// peek validated an unlocked read; re-check against the row now that
+		// it's locked, in case a concurrent move changed it in between.
+		if r.Location.Category != tape.StorageSlot && r.Location.Category != tape.ImportExportSlot {
+			return nil, errors.E(op, errors.Strf("invalid source slot for transfer operation"))
+		}
+
+		// set transfering flag
+		bitmask.Set(&r.Flags, tape.StatusTransfering)
+
+		if err := t.markTransit(r); err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+	if err != nil {
 		return err
 	}
 
-	if err := chgr.Transfer(r.Location, dst); err != nil {
+	r := v.(rvol)
+
+	if err := changer.CallTransfer(ctx, chgr, p.unloadTimeout, r.Location, dst); err != nil {
 		return err
 	}
 
 	bitmask.Clear(&r.Flags, tape.StatusTransfering)
 
-	stmt = `
-		UPDATE volumes
-		SET
-			location = $1,
-			flags = $2
-		WHERE serial = $3
-	`
-
-	if _, err := tx.Exec(stmt, dst, r.Flags, r.Serial); err != nil {
+	_, err = p.tr.Transact(ctx, func(txi inv.Tx) (interface{}, error) {
+		return nil, txi.(*tx).resolve(r, dst)
+	})
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return p.journal.Complete(ctx, txnID)
 }
 
 func (p *postgres) Loaded(loc tape.Location) (loaded bool, serial tape.Serial, err error) {
@@ -503,60 +593,145 @@ func (p *postgres) Update(vol tape.Volume) error {
 	return nil
 }
 
-func (p *postgres) Alloc() (serial tape.Serial, err error) {
-	const op = "inv/postgres.Alloc"
+func (p *postgres) Alloc() (tape.Serial, error) {
+	return p.AllocCtx(context.Background())
+}
+
+// AllocCtx is Alloc, bounded by ctx.
+func (p *postgres) AllocCtx(ctx context.Context) (tape.Serial, error) {
+	v, err := p.tr.Transact(ctx, func(txi inv.Tx) (interface{}, error) {
+		t := txi.(*tx)
+
+		var r rvol
+
+		stmt := `
+			SELECT serial, location, home, category, flags
+			FROM volumes
+			WHERE category IN ('filling', 'scratch')
+			  AND (location).category = 'storage'
+			ORDER BY category, serial
+			LIMIT 1
+			FOR UPDATE
+		`
 
-	tx, err := p.db.Beginx()
+		if err := t.Get(&r, stmt); err != nil {
+			return nil, err
+		}
+
+		if r.Category != tape.Filling {
+			r.Category = tape.Allocating
+
+			if _, err := t.Exec(`UPDATE volumes SET category = $1 WHERE serial = $2`, r.Category, r.Serial); err != nil {
+				return nil, err
+			}
+		}
+
+		return r.Serial, nil
+	})
 	if err != nil {
-		return serial, err
+		return "", err
 	}
 
-	var r rvol
+	return v.(tape.Serial), nil
+}
 
-	stmt := `
-		SELECT serial, location, home, category, flags
-		FROM volumes
-		WHERE category IN ('filling', 'scratch')
-		  AND (location).category = 'storage'
-		ORDER BY category, serial
-		LIMIT 1
-		FOR UPDATE
-	`
+// Reset drops and recreates the inventory schema by migrating down to 0 and
+// back up to head. Unlike Migrate, this always discards any data the schema
+// holds.
+func (p *postgres) Reset() error {
+	const op = "inv/postgres.Reset"
 
-	if err := tx.Get(&r, stmt); err != nil {
-		return serial, rollback(op, tx, err)
+	ctx := context.Background()
+
+	if err := p.migrator.Migrate(ctx, 0); err != nil {
+		return errors.E(op, err)
 	}
 
-	serial = r.Serial
+	if err := p.migrator.Migrate(ctx, p.migrator.Head()); err != nil {
+		return errors.E(op, err)
+	}
 
-	if r.Category != tape.Filling {
-		r.Category = tape.Allocating
+	return nil
+}
 
-		stmt = `
-			UPDATE volumes
-			SET category = $1
-			WHERE serial = $2
-		`
+// Recover replays whatever journal entries a crash left open, reconciling
+// each against the changer's actual state.
+func (p *postgres) Recover(ctx context.Context, chgr changer.Changer) error {
+	const op = "inv/postgres.Recover"
 
-		if _, err = tx.Exec(stmt, r.Category, r.Serial); err != nil {
-			return serial, rollback(op, tx, err)
-		}
+	entries, err := p.journal.Open(ctx)
+	if err != nil {
+		return errors.E(op, err)
 	}
 
-	if err := commit(op, tx); err != nil {
-		return serial, err
+	for _, e := range entries {
+		if err := p.reconcile(ctx, chgr, e); err != nil {
+			return errors.E(op, errors.Strf("reconciling txn %s: %v", e.TxnID, err))
+		}
 	}
 
-	return serial, nil
+	return nil
 }
 
-// Reset resets the inventory database.
-func (p *postgres) Reset() error {
-	for _, stmt := range resetSchema {
-		if _, err := p.db.Exec(stmt); err != nil {
-			return err
+// reconcile asks the changer whether the move journaled by e actually
+// finished. If it did, the resolving DB update that the crash interrupted is
+// replayed; if it didn't, the volume's logical location is rolled back to
+// where the journal entry says the move started.
+func (p *postgres) reconcile(ctx context.Context, chgr changer.Changer, e journal.Entry) error {
+	slots, err := changer.CallStatus(ctx, chgr, p.statusTimeout)
+	if err != nil {
+		return err
+	}
+
+	finished := false
+
+	for _, slot := range slots[e.Dst.Category] {
+		if slot.Addr == e.Dst.Addr {
+			finished = slot.Volume != nil && slot.Volume.Serial == e.Serial
+			break
 		}
 	}
 
-	return nil
+	_, err = p.tr.Transact(ctx, func(txi inv.Tx) (interface{}, error) {
+		t := txi.(*tx)
+
+		r, err := t.lock(e.Serial)
+		if err != nil {
+			return nil, err
+		}
+
+		bitmask.Clear(&r.Flags, tape.StatusTransfering)
+
+		loc := e.Src
+
+		if finished {
+			loc = e.Dst
+
+			switch e.Op {
+			case journal.OpLoad:
+				bitmask.Set(&r.Flags, tape.StatusMounted)
+
+				if r.Category == tape.Allocating {
+					r.Category = tape.Allocated
+				}
+			case journal.OpUnload:
+				bitmask.Clear(&r.Flags, tape.StatusMounted)
+
+				if err := t.clearHome(r.Serial); err != nil {
+					return nil, err
+				}
+			case journal.OpTransfer:
+			}
+		} else if e.Op == journal.OpUnload {
+			// the volume never left the drive
+			bitmask.Set(&r.Flags, tape.StatusMounted)
+		}
+
+		return nil, t.resolve(r, loc)
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.journal.Complete(ctx, e.TxnID)
 }
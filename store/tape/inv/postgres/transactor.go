@@ -0,0 +1,78 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"tapr.space/log"
+	"tapr.space/store/tape/inv"
+)
+
+// SQLSTATE codes postgres returns when a transaction must be retried rather
+// than treated as a hard failure.
+const (
+	sqlstateSerializationFailure = "40001"
+	sqlstateDeadlockDetected     = "40P01"
+)
+
+// transactor is the postgres inv.Transactor. It retries closures that fail due
+// to serialization failures or deadlocks, using inv.Retry for the actual
+// retry/backoff bookkeeping.
+type transactor struct {
+	db     *sqlx.DB
+	policy inv.RetryPolicy
+}
+
+var _ inv.Transactor = (*transactor)(nil)
+
+func (t *transactor) Transact(ctx context.Context, fn inv.RetryableFunc) (interface{}, error) {
+	return inv.Retry(ctx, t.policy, t.begin, fn, isRetryable)
+}
+
+func (t *transactor) begin(ctx context.Context) (inv.Tx, error) {
+	// Serializable isolation is what makes sqlstateSerializationFailure
+	// reachable at all: under the default READ COMMITTED, postgres never
+	// raises 40001, so isRetryable's serialization-failure branch would
+	// otherwise be dead.
+	sqlxTx, err := t.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx{Tx: sqlxTx}, nil
+}
+
+// isRetryable reports whether err is a postgres error the transactor should
+// retry rather than surface to the caller.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !stderrors.As(err, &pqErr) {
+		return false
+	}
+
+	switch pqErr.Code {
+	case sqlstateSerializationFailure, sqlstateDeadlockDetected:
+		log.Info.Printf("inv/postgres: retrying transaction after %s", pqErr.Code)
+		return true
+	default:
+		return false
+	}
+}
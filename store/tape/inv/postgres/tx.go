@@ -0,0 +1,95 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv"
+)
+
+// tx wraps a *sqlx.Tx with the rvol select/update helpers shared by Load,
+// Unload, Transfer and Recover.
+type tx struct {
+	*sqlx.Tx
+}
+
+var _ inv.Tx = (*tx)(nil)
+
+// lock selects and locks the volume row for serial.
+func (t *tx) lock(serial tape.Serial) (rvol, error) {
+	var r rvol
+
+	stmt := `
+		SELECT serial, location, home, category, flags
+		FROM volumes
+		WHERE serial = $1
+		FOR UPDATE
+	`
+
+	err := t.Get(&r, stmt, serial)
+
+	return r, err
+}
+
+// markTransit clears the volume's location, recording it as in flight, and
+// stores its current flags. It is called before handing the move off to the
+// changer.
+func (t *tx) markTransit(r rvol) error {
+	_, err := t.Exec(`
+		UPDATE volumes
+		SET location = NULL, flags = $1
+		WHERE serial = $2
+	`, fmt.Sprintf("%b", r.Flags), r.Serial)
+
+	return err
+}
+
+// resolve records the volume's resolved location, category and flags once the
+// changer has finished moving it.
+func (t *tx) resolve(r rvol, loc tape.Location) error {
+	_, err := t.Exec(`
+		UPDATE volumes
+		SET location = ($1, $2), category = $3, flags = $4
+		WHERE serial = $5
+	`, loc.Addr, loc.Category, r.Category, fmt.Sprintf("%b", r.Flags), r.Serial)
+
+	return err
+}
+
+// setHome records loc as the volume's home slot.
+func (t *tx) setHome(serial tape.Serial, loc tape.Location) error {
+	_, err := t.Exec(`
+		UPDATE volumes
+		SET home = ($1, $2)
+		WHERE serial = $3
+	`, loc.Addr, loc.Category, serial)
+
+	return err
+}
+
+// clearHome clears the volume's home slot.
+func (t *tx) clearHome(serial tape.Serial) error {
+	_, err := t.Exec(`
+		UPDATE volumes
+		SET home = NULL
+		WHERE serial = $1
+	`, serial)
+
+	return err
+}
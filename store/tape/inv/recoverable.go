@@ -0,0 +1,48 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv
+
+import (
+	"context"
+
+	"tapr.space/store/tape/changer"
+)
+
+// Recoverable is implemented by Inventory backends that journal their
+// Load/Unload/Transfer operations (see store/tape/inv/journal) so a crash
+// between the "in flight" commit and the commit that resolves the changer's
+// result can be reconciled. The caller that wires up a backend together with
+// its changer should call Recover once at startup, before serving any
+// requests.
+type Recoverable interface {
+	// Recover replays whatever journal entries were left open by a crash,
+	// consulting chgr.Status to tell whether the move actually completed, and
+	// either finishes the resolving DB update or rolls the volume's logical
+	// location back to where the journal entry says it started.
+	Recover(ctx context.Context, chgr changer.Changer) error
+}
+
+// Recover calls i.Recover if i implements Recoverable, and is a no-op for
+// backends that don't journal their moves. Wiring code should call this once,
+// right after constructing a backend and its changer and before serving any
+// requests, rather than type-asserting Recoverable itself.
+func Recover(ctx context.Context, i Inventory, chgr changer.Changer) error {
+	r, ok := i.(Recoverable)
+	if !ok {
+		return nil
+	}
+
+	return r.Recover(ctx, chgr)
+}
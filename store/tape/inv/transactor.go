@@ -0,0 +1,136 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv
+
+import (
+	"context"
+	"time"
+)
+
+// Tx is the minimal transaction handle a Transactor hands to a RetryableFunc.
+// Backends embed this behind a richer, backend-specific type that carries the
+// actual driver transaction and whatever query helpers it needs.
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+// RetryableFunc is run inside a transaction managed by a Transactor. It may be
+// invoked more than once: if it (or the subsequent commit) returns an error the
+// Transactor considers retryable, the transaction is rolled back and fn is run
+// again against a fresh Tx.
+type RetryableFunc func(tx Tx) (interface{}, error)
+
+// Transactor runs closures inside a transaction, committing on success and
+// rolling back on error, retrying on serialization failures and deadlocks. It
+// follows the pattern of FoundationDB's Transact and is meant to be reusable by
+// any inv.Inventory backend, not just postgres.
+type Transactor interface {
+	// Transact begins a transaction, invokes fn with it, and commits if fn
+	// returns a nil error. If fn's error (or the commit error) is retryable, the
+	// transaction is rolled back, the Transactor backs off, and fn is retried,
+	// up to the bound configured for the Transactor. The value fn returns is
+	// passed through once the transaction has committed.
+	Transact(ctx context.Context, fn RetryableFunc) (interface{}, error)
+}
+
+// RetryPolicy bounds how many times, and with what backoff, a Transactor
+// retries a RetryableFunc after a retryable error.
+type RetryPolicy struct {
+	// MaxRetries is the number of additional attempts after the first. Zero
+	// disables retrying.
+	MaxRetries int
+
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the exponentially growing delay between retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used by backends that don't configure their own: five
+// retries, backing off from 5ms up to 500ms.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     5,
+	InitialBackoff: 5 * time.Millisecond,
+	MaxBackoff:     500 * time.Millisecond,
+}
+
+// Backoff returns the delay before retry attempt n (n=1 for the first retry),
+// doubling InitialBackoff each attempt up to MaxBackoff.
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	return d
+}
+
+// Retry is a helper a backend's Transactor.Transact can delegate to: it begins
+// a transaction via begin, runs fn, commits on success, and otherwise rolls
+// back and retries according to policy for as long as isRetryable(err) holds.
+func Retry(
+	ctx context.Context,
+	policy RetryPolicy,
+	begin func(context.Context) (Tx, error),
+	fn RetryableFunc,
+	isRetryable func(error) bool,
+) (interface{}, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= policy.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(policy.Backoff(attempt)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		tx, err := begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := fn(tx)
+		if err != nil {
+			_ = tx.Rollback()
+
+			if isRetryable(err) {
+				lastErr = err
+				continue
+			}
+
+			return nil, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			if isRetryable(err) {
+				lastErr = err
+				continue
+			}
+
+			return nil, err
+		}
+
+		return val, nil
+	}
+
+	return nil, lastErr
+}
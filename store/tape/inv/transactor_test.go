@@ -0,0 +1,193 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 5 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+	}
+
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 5 * time.Millisecond},
+		{2, 10 * time.Millisecond},
+		{3, 20 * time.Millisecond},
+		{4, 20 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		if got := p.Backoff(c.attempt); got != c.want {
+			t.Errorf("Backoff(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+type fakeTx struct {
+	committed, rolledBack bool
+	commitErr             error
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.committed = true
+	return tx.commitErr
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.rolledBack = true
+	return nil
+}
+
+func TestRetrySucceedsWithoutRetry(t *testing.T) {
+	tx := &fakeTx{}
+
+	begin := func(context.Context) (Tx, error) { return tx, nil }
+
+	val, err := Retry(context.Background(), DefaultRetryPolicy, begin, func(Tx) (interface{}, error) {
+		return "ok", nil
+	}, func(error) bool { return false })
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+
+	if val != "ok" {
+		t.Errorf("val = %v, want ok", val)
+	}
+
+	if !tx.committed {
+		t.Error("expected tx to be committed")
+	}
+}
+
+func TestRetryRetriesRetryableErrors(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	var txs []*fakeTx
+
+	begin := func(context.Context) (Tx, error) {
+		tx := &fakeTx{}
+		txs = append(txs, tx)
+		return tx, nil
+	}
+
+	attempts := 0
+	retryable := errors.New("serialization failure")
+
+	val, err := Retry(context.Background(), policy, begin, func(Tx) (interface{}, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, retryable
+		}
+
+		return "ok", nil
+	}, func(err error) bool { return err == retryable })
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+
+	if val != "ok" {
+		t.Errorf("val = %v, want ok", val)
+	}
+
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+
+	for i, tx := range txs[:len(txs)-1] {
+		if !tx.rolledBack {
+			t.Errorf("tx %d: expected rollback", i)
+		}
+	}
+
+	if !txs[len(txs)-1].committed {
+		t.Error("expected final tx to be committed")
+	}
+}
+
+func TestRetryGivesUpAfterMaxRetries(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	begin := func(context.Context) (Tx, error) { return &fakeTx{}, nil }
+
+	retryable := errors.New("serialization failure")
+
+	attempts := 0
+
+	_, err := Retry(context.Background(), policy, begin, func(Tx) (interface{}, error) {
+		attempts++
+		return nil, retryable
+	}, func(err error) bool { return err == retryable })
+	if err != retryable {
+		t.Fatalf("err = %v, want %v", err, retryable)
+	}
+
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3 (1 initial + 2 retries)", attempts)
+	}
+}
+
+func TestRetryStopsOnNonRetryableError(t *testing.T) {
+	begin := func(context.Context) (Tx, error) { return &fakeTx{}, nil }
+
+	fatal := errors.New("not retryable")
+
+	attempts := 0
+
+	_, err := Retry(context.Background(), DefaultRetryPolicy, begin, func(Tx) (interface{}, error) {
+		attempts++
+		return nil, fatal
+	}, func(error) bool { return false })
+	if err != fatal {
+		t.Fatalf("err = %v, want %v", err, fatal)
+	}
+
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestRetryRespectsContextCancellation(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+
+	begin := func(context.Context) (Tx, error) { return &fakeTx{}, nil }
+
+	retryable := errors.New("serialization failure")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+
+	_, err := Retry(ctx, policy, begin, func(Tx) (interface{}, error) {
+		attempts++
+		cancel()
+		return nil, retryable
+	}, func(error) bool { return true })
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}